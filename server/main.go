@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"bytes"
 	"encoding/json"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/Ordspilleren/Tilted/server/metrics"
+	"github.com/Ordspilleren/Tilted/server/mqtt"
+	"github.com/Ordspilleren/Tilted/server/outputs"
 	"github.com/Ordspilleren/Tilted/server/web"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -58,6 +62,27 @@ type SensorData struct {
 // Global database connection pool
 var dbPool *sqlitex.Pool
 var brewfatherForwardURL string
+var mqttClient *mqtt.Client
+var outputsManager *outputs.Manager
+var dbConnsInUse int32
+
+// takeConn checks out a connection from pool, keeping the
+// tilted_db_pool_in_use gauge in sync.
+func takeConn(pool *sqlitex.Pool) (*sqlite.Conn, error) {
+	conn, err := pool.Take(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	metrics.DBPoolInUse.Set(float64(atomic.AddInt32(&dbConnsInUse, 1)))
+	return conn, nil
+}
+
+// putConn returns a connection to pool, keeping the tilted_db_pool_in_use
+// gauge in sync.
+func putConn(pool *sqlitex.Pool, conn *sqlite.Conn) {
+	pool.Put(conn)
+	metrics.DBPoolInUse.Set(float64(atomic.AddInt32(&dbConnsInUse, -1)))
+}
 
 func main() {
 	// Initialize SQLite database
@@ -68,6 +93,10 @@ func main() {
 	}
 	defer dbPool.Close()
 
+	// Periodically roll raw readings into the 5m/1h aggregate tables and
+	// prune raw readings past the retention window.
+	startRetentionLoop(dbPool, retentionCfg)
+
 	// Create a new Echo instance
 	e := echo.New()
 
@@ -86,6 +115,9 @@ func main() {
 	e.GET("/api/sensors", getSensorIDs)
 	e.GET("/api/readings/:sensorId", getSensorData)
 	e.GET("/health", healthCheck)
+	e.POST("/api/admin/rollup", handleManualRollup)
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	e.GET("/api/stream", handleStream)
 
 	// Serve Svelte frontend for all other routes
 	e.GET("/*", echo.WrapHandler(web.FrontEndHandler))
@@ -99,6 +131,61 @@ func main() {
 	if brewfatherForwardURL != "" {
 		log.Printf("Brewfather forward enabled -> %s", brewfatherForwardURL)
 	}
+
+	// Optional: ingest readings from an MQTT broker instead of (or in
+	// addition to) HTTP. Set MQTT_BROKER_URL to enable, e.g.
+	// tcp://broker.local:1883.
+	if cfg, ok := mqtt.ConfigFromEnv(); ok {
+		client, err := mqtt.New(cfg, handleMqttMessage)
+		if err != nil {
+			log.Fatalf("Failed to start MQTT client: %v", err)
+		}
+		mqttClient = client
+		defer mqttClient.Close()
+
+		topic := cfg.TopicPrefix + "/+/reading"
+		if err := mqttClient.Subscribe(topic); err != nil {
+			log.Fatalf("Failed to subscribe to %s: %v", topic, err)
+		}
+		log.Printf("MQTT ingest enabled -> %s (topic %s)", cfg.BrokerURL, topic)
+	}
+
+	// Load the pluggable output forwarders (Brewfather, generic HTTP
+	// webhooks, InfluxDB, MQTT, local file) from outputs.yaml and/or
+	// TILTED_OUTPUTS_* env vars, and keep the legacy BREWFATHER_FORWARD_URL
+	// / MQTT_PUBLISH_ON_SAVE env vars working as implicit outputs.
+	outCfgs, err := outputs.LoadConfigFile("outputs.yaml")
+	if err != nil {
+		log.Fatalf("Failed to load outputs.yaml: %v", err)
+	}
+	outCfgs = append(outCfgs, outputs.LoadConfigFromEnv()...)
+	if brewfatherForwardURL != "" {
+		outCfgs = append(outCfgs, outputs.Config{
+			Type:    "brewfather",
+			Name:    "brewfather-env",
+			Options: map[string]string{"url": brewfatherForwardURL},
+		})
+	}
+	if mqttClient != nil && mqttClient.PublishOnSave() {
+		outCfgs = append(outCfgs, outputs.Config{
+			Type:    "mqtt",
+			Name:    "mqtt-normalized",
+			Options: map[string]string{"prefix": mqttClient.Prefix()},
+		})
+	}
+
+	var outDeps outputs.Deps
+	if mqttClient != nil {
+		outDeps.MQTTPublisher = mqttClient
+	}
+
+	outputsManager, err = outputs.Build(outCfgs, outputs.WorkerCount(), outDeps)
+	if err != nil {
+		log.Fatalf("Failed to initialize outputs: %v", err)
+	}
+	defer outputsManager.Close()
+	log.Printf("Outputs enabled: %d", len(outCfgs))
+
 	log.Printf("Starting server on port %s", port)
 	if err := e.Start(port); err != http.ErrServerClosed {
 		log.Fatal(err)
@@ -108,7 +195,9 @@ func main() {
 // initDB initializes the SQLite database and creates necessary tables
 func initDB() (*sqlitex.Pool, error) {
 	databaseLocation := flag.String("database", "tilted.db", "")
+	rawRetention, rollupInterval := registerRetentionFlags()
 	flag.Parse()
+	retentionCfg = retentionConfig{rawRetention: *rawRetention, rollupInterval: *rollupInterval}
 
 	// Ensure parent directory exists and the database file is present so
 	// sqlite can open it. Some environments (containers, fresh deploys)
@@ -135,11 +224,11 @@ func initDB() (*sqlitex.Pool, error) {
 	}
 
 	// Get a connection to create tables
-	conn, err := pool.Take(context.Background())
+	conn, err := takeConn(pool)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %v", err)
 	}
-	defer pool.Put(conn)
+	defer putConn(pool, conn)
 
 	// Create tables with a normalized schema
 	createTablesSQL := `
@@ -173,7 +262,7 @@ func initDB() (*sqlitex.Pool, error) {
     
     -- Create index for querying by sensor_id
     CREATE INDEX IF NOT EXISTS idx_readings_sensor_id ON readings(sensor_id);
-    `
+    ` + createRollupTablesSQL
 
 	err = sqlitex.ExecuteScript(conn, createTablesSQL, nil)
 	if err != nil {
@@ -205,8 +294,7 @@ func handleSensorData(c echo.Context) error {
 		sensorData.Reading.Tilt,
 		sensorData.Reading.Temp)
 
-	// Save data to SQLite
-	if err := saveToDatabase(sensorData); err != nil {
+	if err := ingest(sensorData, "http"); err != nil {
 		log.Printf("Error saving to database: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"status": "error",
@@ -214,28 +302,90 @@ func handleSensorData(c echo.Context) error {
 		})
 	}
 
-	// Optionally forward the reading to Brewfather (or any HTTP endpoint)
-	if brewfatherForwardURL != "" {
-		go func(sd *SensorReading) {
-			if err := forwardToBrewfather(sd); err != nil {
-				log.Printf("Failed to forward to Brewfather: %v", err)
-			}
-		}(sensorData)
-	}
-
 	return c.JSON(http.StatusOK, map[string]string{
 		"status": "success",
 	})
 }
 
-// saveToDatabase stores the sensor readings in SQLite with normalized schema
-func saveToDatabase(data *SensorReading) error {
+// ingest is the single entry point readings flow through regardless of
+// transport (HTTP POST, the gateway JSON endpoint, or MQTT): it persists
+// the reading and then fans it out to every configured output, so a
+// reading ingested over MQTT is treated identically to one posted over
+// HTTP. transport is recorded on the received-readings counter (e.g.
+// "http", "mqtt").
+func ingest(sr *SensorReading, transport string) error {
+	metrics.ReadingsReceivedTotal.WithLabelValues(transport, gatewayMetricLabel(sr.GatewayID)).Inc()
+
+	timestamp, err := saveToDatabase(sr)
+	if err != nil {
+		return err
+	}
+
+	if outputsManager != nil {
+		outputsManager.Dispatch(toOutputsReading(sr, timestamp))
+	}
+
+	return nil
+}
+
+// gatewayMetricLabel derives a low-cardinality label value for the
+// tilted_readings_received_total gateway dimension. The MQTT and legacy
+// HTTP transports pass a stable, declared gateway ID, but the lightweight
+// gateway JSON endpoint falls back to c.Request().RemoteAddr
+// (host:ephemeral-port), which would give every reconnect its own label
+// value; stripping the port keeps the label bounded to one value per
+// gateway host.
+func gatewayMetricLabel(gatewayID string) string {
+	host, _, err := net.SplitHostPort(gatewayID)
+	if err != nil {
+		return gatewayID
+	}
+	return host
+}
+
+// saveToDatabase stores the sensor readings in SQLite with normalized
+// schema, recording store counters/gauges for Prometheus along the way. It
+// returns the timestamp the reading was stored under, so callers can pass
+// it on to outputs instead of outputs recording their own write-time.
+func saveToDatabase(data *SensorReading) (int64, error) {
+	timestamp, err := insertReading(data)
+	if err != nil {
+		metrics.ReadingsStoreErrorsTotal.Inc()
+		return 0, err
+	}
+
+	metrics.ReadingsStoredTotal.Inc()
+	metrics.RecordReading(data.Reading.SensorID, time.Now(), data.Reading.Gravity, data.Reading.Temp, data.Reading.Volt)
+
+	// Published only after insertReading's transaction has committed, so
+	// subscribers never see an event for a row that didn't durably land.
+	streamHub.Publish(StreamEvent{
+		DataPoint: DataPoint{
+			Timestamp: timestamp,
+			Gravity:   data.Reading.Gravity,
+			Tilt:      data.Reading.Tilt,
+			Temp:      data.Reading.Temp,
+			Volt:      data.Reading.Volt,
+			Interval:  data.Reading.Interval,
+		},
+		SensorID:    data.Reading.SensorID,
+		GatewayName: data.GatewayName,
+	})
+
+	return timestamp, nil
+}
+
+// insertReading does the actual normalized-schema insert; it's split out
+// from saveToDatabase so the latter stays a thin wrapper for recording
+// metrics regardless of which code path below fails. It returns the
+// timestamp the reading was stored under.
+func insertReading(data *SensorReading) (int64, error) {
 	// Get a connection from the pool
-	conn, err := dbPool.Take(context.Background())
+	conn, err := takeConn(dbPool)
 	if err != nil {
-		return fmt.Errorf("failed to get database connection: %v", err)
+		return 0, fmt.Errorf("failed to get database connection: %v", err)
 	}
-	defer dbPool.Put(conn)
+	defer putConn(dbPool, conn)
 
 	// Begin transaction using the Transaction helper
 	endTx := sqlitex.Transaction(conn)
@@ -260,7 +410,7 @@ func saveToDatabase(data *SensorReading) error {
 		})
 
 	if err != nil {
-		return fmt.Errorf("failed to query sensor: %v", err)
+		return 0, fmt.Errorf("failed to query sensor: %v", err)
 	}
 
 	if !found {
@@ -271,7 +421,7 @@ func saveToDatabase(data *SensorReading) error {
 				Args: []any{data.Reading.SensorID},
 			})
 		if err != nil {
-			return fmt.Errorf("failed to insert sensor: %v", err)
+			return 0, fmt.Errorf("failed to insert sensor: %v", err)
 		}
 
 		// Get the last insert ID
@@ -282,7 +432,7 @@ func saveToDatabase(data *SensorReading) error {
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("failed to get sensor ID: %v", err)
+			return 0, fmt.Errorf("failed to get sensor ID: %v", err)
 		}
 	}
 
@@ -302,7 +452,7 @@ func saveToDatabase(data *SensorReading) error {
 		})
 
 	if err != nil {
-		return fmt.Errorf("failed to query gateway: %v", err)
+		return 0, fmt.Errorf("failed to query gateway: %v", err)
 	}
 
 	if !found {
@@ -313,7 +463,7 @@ func saveToDatabase(data *SensorReading) error {
 				Args: []any{data.GatewayID, data.GatewayName},
 			})
 		if err != nil {
-			return fmt.Errorf("failed to insert gateway: %v", err)
+			return 0, fmt.Errorf("failed to insert gateway: %v", err)
 		}
 
 		// Get the last insert ID
@@ -324,7 +474,7 @@ func saveToDatabase(data *SensorReading) error {
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("failed to get gateway ID: %v", err)
+			return 0, fmt.Errorf("failed to get gateway ID: %v", err)
 		}
 	}
 
@@ -342,17 +492,17 @@ func saveToDatabase(data *SensorReading) error {
 			},
 		})
 	if err != nil {
-		return fmt.Errorf("failed to insert reading: %v", err)
+		return 0, fmt.Errorf("failed to insert reading: %v", err)
 	}
 
 	log.Printf("Successfully saved metrics to SQLite database")
-	return nil
+	return timestamp, nil
 }
 
 // healthCheck provides a simple health check endpoint
 func healthCheck(c echo.Context) error {
 	// Get a connection from the pool to check if database is available
-	conn, err := dbPool.Take(context.Background())
+	conn, err := takeConn(dbPool)
 	if err != nil {
 		return c.JSON(http.StatusServiceUnavailable, map[string]string{
 			"status": "error",
@@ -360,7 +510,7 @@ func healthCheck(c echo.Context) error {
 			"time":   time.Now().Format(time.RFC3339),
 		})
 	}
-	dbPool.Put(conn)
+	putConn(dbPool, conn)
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"status": "ok",
@@ -368,16 +518,32 @@ func healthCheck(c echo.Context) error {
 	})
 }
 
+// handleManualRollup triggers an out-of-band rollup/retention sweep,
+// useful after bulk-loading historical data or when debugging why a
+// chart isn't showing recent aggregates yet.
+func handleManualRollup(c echo.Context) error {
+	if err := rollupAndPrune(dbPool, retentionCfg); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"status": "error",
+			"error":  err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
 // getSensorIDs retrieves all unique sensor IDs from the database
 func getSensorIDs(c echo.Context) error {
 	// Get a connection from the pool
-	conn, err := dbPool.Take(context.Background())
+	conn, err := takeConn(dbPool)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to get database connection: %v", err),
 		})
 	}
-	defer dbPool.Put(conn)
+	defer putConn(dbPool, conn)
 
 	var sensorIDs []string
 
@@ -451,13 +617,13 @@ func getSensorData(c echo.Context) error {
 	}
 
 	// Get a connection from the pool
-	conn, err := dbPool.Take(context.Background())
+	conn, err := takeConn(dbPool)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to get database connection: %v", err),
 		})
 	}
-	defer dbPool.Put(conn)
+	defer putConn(dbPool, conn)
 
 	// Prepare data structure for response
 	sensorDataResult := SensorData{
@@ -465,25 +631,14 @@ func getSensorData(c echo.Context) error {
 		DataPoints: []DataPoint{},
 	}
 
-	// Query the database for sensor data with JOINs to get the necessary information
-	query := `
-    SELECT 
-        r.timestamp, s.sensor_id, g.gateway_id, g.gateway_name,
-        r.gravity, r.tilt, r.temp, r.volt, r.interval
-    FROM 
-        readings r
-    JOIN 
-        sensors s ON r.sensor_id = s.id
-    JOIN 
-        gateways g ON r.gateway_id = g.id
-    WHERE 
-        s.sensor_id = ? AND r.timestamp >= ? AND r.timestamp <= ?
-    ORDER BY 
-        r.timestamp ASC
-    `
+	// Pick the coarsest resolution that still covers the requested span
+	// without decimating long-range charts, falling back to raw readings
+	// for anything a day or less.
+	res := chooseResolution(startTime, endTime)
+	query, args := sensorDataQuery(res, sensorID, startTime, endTime)
 
 	err = sqlitex.Execute(conn, query, &sqlitex.ExecOptions{
-		Args: []any{sensorID, startTime, endTime},
+		Args: args,
 		ResultFunc: func(stmt *sqlite.Stmt) error {
 			// Set gateway info if not already set (it should be the same for all readings of a sensor in a request)
 			if sensorDataResult.GatewayID == "" {
@@ -530,6 +685,32 @@ func handleGatewayJson(c echo.Context) error {
 	}
 
 	// Map fields with best-effort conversions.
+	reading := gatewayPayloadToReading(payload)
+
+	// Use remote address as a fallback gateway identifier
+	gatewayID := c.Request().RemoteAddr
+	gatewayName := gatewayID
+
+	sr := &SensorReading{
+		Reading:     reading,
+		GatewayID:   gatewayID,
+		GatewayName: gatewayName,
+	}
+
+	// Save and forward using the shared ingest pipeline
+	if err := ingest(sr, "http"); err != nil {
+		log.Printf("Error saving gateway payload: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"status": "error", "error": "Failed to store metrics"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// gatewayPayloadToReading maps the lightweight JSON payload produced by the
+// ESP32 gateway (see handleGatewayJson) into a Reading. It's shared by the
+// HTTP and MQTT transports so a gateway pointed at either one is decoded
+// identically.
+func gatewayPayloadToReading(payload map[string]any) Reading {
 	reading := Reading{}
 	if v, ok := payload["gravity"]; ok {
 		if f, ok2 := v.(float64); ok2 {
@@ -558,70 +739,57 @@ func handleGatewayJson(c echo.Context) error {
 		}
 	}
 
-	sensorId := "unknown"
+	reading.SensorID = "unknown"
 	if v, ok := payload["name"]; ok {
 		if s, ok2 := v.(string); ok2 && s != "" {
-			sensorId = s
+			reading.SensorID = s
 		}
 	}
 
-	// Use remote address as a fallback gateway identifier
-	gatewayID := c.Request().RemoteAddr
-	gatewayName := gatewayID
-
-	sr := &SensorReading{
-		Reading:     reading,
-		GatewayID:   gatewayID,
-		GatewayName: gatewayName,
-	}
-	// Use sensorID as the reading.SensorID
-	sr.Reading.SensorID = sensorId
-
-	// Save and optionally forward using existing logic
-	if err := saveToDatabase(sr); err != nil {
-		log.Printf("Error saving gateway payload: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"status": "error", "error": "Failed to store metrics"})
-	}
-
-	if brewfatherForwardURL != "" {
-		go func(sd *SensorReading) {
-			if err := forwardToBrewfather(sd); err != nil {
-				log.Printf("Failed to forward to Brewfather: %v", err)
-			}
-		}(sr)
-	}
-
-	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	return reading
 }
 
-// forwardToBrewfather sends the incoming sensor reading JSON to the configured
-// Brewfather-forward URL. It's intentionally simple: one attempt, logged on
-// failure. Caller should run this in a goroutine if they don't want blocking.
-func forwardToBrewfather(data *SensorReading) error {
-	// Reuse the incoming JSON shape for forwarding unless the target
-	// requires a different payload. This keeps the gateway side simple
-	// â€” you can point the gateway's Brewfather URL at this server and it
-	// will be proxied onward as configured here.
-	b, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("marshal: %w", err)
+// handleMqttMessage decodes a payload received on a `<prefix>/+/reading`
+// topic and feeds it through the same ingest pipeline as the HTTP handlers.
+// The gateway ID/name aren't carried over MQTT, so the full topic the
+// message arrived on is used as both the gateway ID and gateway name; the
+// sensor ID comes from the payload's "name" field, as for HTTP.
+func handleMqttMessage(topic string, payload []byte) {
+	var raw map[string]any
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		log.Printf("Failed to decode MQTT payload on %s: %v", topic, err)
+		return
 	}
 
-	req, err := http.NewRequest("POST", brewfatherForwardURL, bytes.NewReader(b))
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+	reading := gatewayPayloadToReading(raw)
+	sr := &SensorReading{
+		Reading:     reading,
+		GatewayID:   topic,
+		GatewayName: topic,
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("http post: %w", err)
+	if err := ingest(sr, "mqtt"); err != nil {
+		log.Printf("Error saving MQTT reading from %s: %v", topic, err)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
+// toOutputsReading converts the server's SensorReading into the shape the
+// outputs package works with, so outputsManager has no dependency on the
+// main package. timestamp is the time the reading was actually stored
+// under (from insertReading), not the time Dispatch happens to run, so a
+// reading that's retried by an output still carries its original time.
+func toOutputsReading(sr *SensorReading, timestamp int64) *outputs.SensorReading {
+	return &outputs.SensorReading{
+		Reading: outputs.Reading{
+			SensorID: sr.Reading.SensorID,
+			Gravity:  sr.Reading.Gravity,
+			Tilt:     sr.Reading.Tilt,
+			Temp:     sr.Reading.Temp,
+			Volt:     sr.Reading.Volt,
+			Interval: sr.Reading.Interval,
+		},
+		GatewayID:   sr.GatewayID,
+		GatewayName: sr.GatewayName,
+		Timestamp:   timestamp,
 	}
-	return nil
 }
@@ -0,0 +1,102 @@
+// Package metrics exposes a Prometheus registry for the ingest and
+// forward paths, similar in spirit to the counters an rqlite server
+// exposes for its own request pipeline. It lets homelab users scrape a
+// Tilted server into Grafana and alert on stale sensors or forward
+// failures without polling /api/readings.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ReadingsReceivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tilted_readings_received_total",
+		Help: "Readings received, by transport and gateway.",
+	}, []string{"transport", "gateway"})
+
+	ReadingsStoredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tilted_readings_stored_total",
+		Help: "Readings successfully persisted to the database.",
+	})
+
+	ReadingsStoreErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tilted_readings_store_errors_total",
+		Help: "Readings that failed to persist to the database.",
+	})
+
+	ForwardAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tilted_forward_attempts_total",
+		Help: "Forward attempts, by output sink.",
+	}, []string{"sink"})
+
+	ForwardFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tilted_forward_failures_total",
+		Help: "Failed forward attempts, by output sink and failure reason.",
+	}, []string{"sink", "reason"})
+
+	ForwardLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tilted_forward_latency_seconds",
+		Help:    "Latency of forwarding a reading to an output sink.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	LastReadingTimestampSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tilted_last_reading_timestamp_seconds",
+		Help: "Unix timestamp of the last reading received, by sensor.",
+	}, []string{"sensor"})
+
+	LastGravity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tilted_last_gravity",
+		Help: "Last reported gravity, by sensor.",
+	}, []string{"sensor"})
+
+	LastTemp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tilted_last_temp",
+		Help: "Last reported temperature, by sensor.",
+	}, []string{"sensor"})
+
+	LastVolt = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tilted_last_volt",
+		Help: "Last reported battery voltage, by sensor.",
+	}, []string{"sensor"})
+
+	DBPoolInUse = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tilted_db_pool_in_use",
+		Help: "Number of database connections currently checked out of the pool.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ReadingsReceivedTotal,
+		ReadingsStoredTotal,
+		ReadingsStoreErrorsTotal,
+		ForwardAttemptsTotal,
+		ForwardFailuresTotal,
+		ForwardLatencySeconds,
+		LastReadingTimestampSeconds,
+		LastGravity,
+		LastTemp,
+		LastVolt,
+		DBPoolInUse,
+	)
+}
+
+// Handler returns the HTTP handler to serve on /metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RecordReading updates the last-value gauges for sensor after a
+// successful save.
+func RecordReading(sensor string, at time.Time, gravity, temp, volt float64) {
+	LastReadingTimestampSeconds.WithLabelValues(sensor).Set(float64(at.Unix()))
+	LastGravity.WithLabelValues(sensor).Set(gravity)
+	LastTemp.WithLabelValues(sensor).Set(temp)
+	LastVolt.WithLabelValues(sensor).Set(volt)
+}
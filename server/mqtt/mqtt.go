@@ -0,0 +1,199 @@
+// Package mqtt wraps an MQTT client so the server can ingest sensor
+// readings from a broker instead of (or alongside) plain HTTP. It is
+// intended for users running their ESP32 gateways behind an MQTT broker
+// rather than exposing the server's HTTP endpoints directly.
+package mqtt
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MessageHandler is invoked for every message received on a subscribed
+// topic. topic is the full MQTT topic the message arrived on.
+type MessageHandler func(topic string, payload []byte)
+
+// Config holds the settings needed to connect to a broker. Use
+// ConfigFromEnv to populate it from the environment.
+type Config struct {
+	BrokerURL     string
+	Username      string
+	Password      string
+	ClientID      string
+	TLSEnabled    bool
+	TLSSkipVerify bool
+	TopicPrefix   string
+	PublishOnSave bool
+}
+
+// ConfigFromEnv builds a Config from environment variables. ok is false
+// (and Config is zero) when MQTT_BROKER_URL is unset, meaning MQTT
+// ingest is disabled.
+func ConfigFromEnv() (Config, bool) {
+	broker := os.Getenv("MQTT_BROKER_URL")
+	if broker == "" {
+		return Config{}, false
+	}
+
+	cfg := Config{
+		BrokerURL:     broker,
+		Username:      os.Getenv("MQTT_USERNAME"),
+		Password:      os.Getenv("MQTT_PASSWORD"),
+		ClientID:      os.Getenv("MQTT_CLIENT_ID"),
+		TLSEnabled:    boolEnv("MQTT_TLS"),
+		TLSSkipVerify: boolEnv("MQTT_TLS_SKIP_VERIFY"),
+		TopicPrefix:   os.Getenv("MQTT_TOPIC_PREFIX"),
+		PublishOnSave: boolEnv("MQTT_PUBLISH_ON_SAVE"),
+	}
+
+	if cfg.ClientID == "" {
+		cfg.ClientID = "tilted-server"
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "tilted"
+	}
+
+	return cfg, true
+}
+
+func boolEnv(name string) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return false
+	}
+	return v
+}
+
+// Client is a reconnecting MQTT client that re-establishes its
+// subscriptions whenever the connection to the broker is lost and
+// re-established.
+type Client struct {
+	cli           paho.Client
+	cfg           Config
+	onMessage     MessageHandler
+	mu            sync.Mutex
+	topics        []string
+	publishOnSave bool
+}
+
+// initialConnectTimeout bounds how long New waits for the first connect
+// attempt before returning control to the caller. SetConnectRetry(true)
+// means the connect token only completes once the broker is reachable,
+// so without this bound an unreachable broker would hang New (and thus
+// the server's startup) indefinitely.
+const initialConnectTimeout = 5 * time.Second
+
+// New starts connecting to the broker described by cfg and returns a
+// Client that delivers messages to onMessage. The underlying client
+// keeps retrying the connection and reconnects automatically on link
+// loss. New does not block on the broker being reachable: it waits up
+// to initialConnectTimeout for the first attempt and otherwise lets the
+// connection complete in the background, so a down/unreachable broker
+// never stalls server startup.
+func New(cfg Config, onMessage MessageHandler) (*Client, error) {
+	c := &Client{
+		cfg:           cfg,
+		onMessage:     onMessage,
+		publishOnSave: cfg.PublishOnSave,
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetKeepAlive(30 * time.Second).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+
+	if cfg.TLSEnabled {
+		opts.SetTLSConfig(&tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify})
+	}
+
+	c.cli = paho.NewClient(opts)
+	token := c.cli.Connect()
+	if !token.WaitTimeout(initialConnectTimeout) {
+		log.Printf("mqtt: still connecting to %s after %s, continuing in the background", cfg.BrokerURL, initialConnectTimeout)
+	} else if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("mqtt: connect: %w", err)
+	}
+
+	return c, nil
+}
+
+// onConnect re-subscribes to every topic the caller previously asked for,
+// so a subscription survives a reconnect without the caller having to
+// track connection state itself.
+func (c *Client) onConnect(cli paho.Client) {
+	c.mu.Lock()
+	topics := append([]string(nil), c.topics...)
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := c.subscribe(topic); err != nil {
+			log.Printf("mqtt: failed to re-subscribe to %s: %v", topic, err)
+		}
+	}
+}
+
+// Subscribe adds topic to the set of tracked subscriptions. If the
+// client is already connected it subscribes immediately; otherwise
+// onConnect subscribes once the (possibly still-pending, see New)
+// connection comes up. The subscription is automatically re-established
+// on every future reconnect either way.
+func (c *Client) Subscribe(topic string) error {
+	c.mu.Lock()
+	c.topics = append(c.topics, topic)
+	c.mu.Unlock()
+
+	if !c.cli.IsConnected() {
+		return nil
+	}
+	return c.subscribe(topic)
+}
+
+func (c *Client) subscribe(topic string) error {
+	token := c.cli.Subscribe(topic, 1, func(_ paho.Client, msg paho.Message) {
+		c.onMessage(msg.Topic(), msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("mqtt: subscribe %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+// Publish publishes payload to topic with QoS 1.
+func (c *Client) Publish(topic string, payload []byte) error {
+	token := c.cli.Publish(topic, 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("mqtt: publish %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+// PublishOnSave reports whether normalized readings should be republished
+// after a successful save (MQTT_PUBLISH_ON_SAVE=true).
+func (c *Client) PublishOnSave() bool {
+	return c.publishOnSave
+}
+
+// Prefix returns the configured topic prefix, e.g. "tilted".
+func (c *Client) Prefix() string {
+	return c.cfg.TopicPrefix
+}
+
+// Close disconnects from the broker, waiting up to 250ms to flush.
+func (c *Client) Close() {
+	c.cli.Disconnect(250)
+}
@@ -0,0 +1,65 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// BrewfatherOutput forwards readings to a Brewfather-compatible custom
+// stream URL, one HTTP POST per reading. This is the behavior that used
+// to live directly in the server as brewfatherForwardURL/forwardToBrewfather.
+type BrewfatherOutput struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewBrewfatherOutput builds a BrewfatherOutput posting to url.
+func NewBrewfatherOutput(name, url string) *BrewfatherOutput {
+	return &BrewfatherOutput{
+		name:   name,
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (o *BrewfatherOutput) Name() string { return o.name }
+
+func (o *BrewfatherOutput) Write(ctx context.Context, readings []*SensorReading) error {
+	for _, r := range readings {
+		if err := o.writeOne(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *BrewfatherOutput) writeOne(ctx context.Context, r *SensorReading) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (o *BrewfatherOutput) Close() error { return nil }
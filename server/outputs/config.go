@@ -0,0 +1,120 @@
+package outputs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes one configured output instance as loaded from
+// outputs.yaml or the TILTED_OUTPUTS_* environment variables.
+type Config struct {
+	Type    string            `yaml:"type"`
+	Name    string            `yaml:"name"`
+	Include []string          `yaml:"include"`
+	Exclude []string          `yaml:"exclude"`
+	Options map[string]string `yaml:"options"`
+}
+
+type fileConfig struct {
+	Outputs []Config `yaml:"outputs"`
+}
+
+// LoadConfigFile reads output definitions from a YAML file such as
+// outputs.yaml. A missing file is not an error: it simply yields no
+// configured outputs.
+func LoadConfigFile(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("outputs: read %s: %w", path, err)
+	}
+
+	var fc fileConfig
+	if err := yaml.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("outputs: parse %s: %w", path, err)
+	}
+
+	for i := range fc.Outputs {
+		if fc.Outputs[i].Name == "" {
+			fc.Outputs[i].Name = fc.Outputs[i].Type
+		}
+	}
+
+	return fc.Outputs, nil
+}
+
+// LoadConfigFromEnv builds output configs from TILTED_OUTPUTS_* variables.
+// TILTED_OUTPUTS lists the output names to enable, e.g.
+// "TILTED_OUTPUTS=brewfather,lab-influx". For each name N, the following
+// variables are read:
+//
+//	TILTED_OUTPUT_<N>_TYPE     (required, e.g. brewfather, http, influxdb, mqtt, file)
+//	TILTED_OUTPUT_<N>_INCLUDE  (comma-separated sensor IDs)
+//	TILTED_OUTPUT_<N>_EXCLUDE  (comma-separated sensor IDs)
+//	TILTED_OUTPUT_<N>_OPT_<K>  (arbitrary per-type option K, e.g. URL, TOKEN)
+func LoadConfigFromEnv() []Config {
+	names := splitCSV(os.Getenv("TILTED_OUTPUTS"))
+	if len(names) == 0 {
+		return nil
+	}
+
+	var cfgs []Config
+	for _, name := range names {
+		envName := envKey(name)
+		cfg := Config{
+			Name:    name,
+			Type:    os.Getenv("TILTED_OUTPUT_" + envName + "_TYPE"),
+			Include: splitCSV(os.Getenv("TILTED_OUTPUT_" + envName + "_INCLUDE")),
+			Exclude: splitCSV(os.Getenv("TILTED_OUTPUT_" + envName + "_EXCLUDE")),
+			Options: map[string]string{},
+		}
+
+		prefix := "TILTED_OUTPUT_" + envName + "_OPT_"
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, prefix) {
+				continue
+			}
+			parts := strings.SplitN(kv, "=", 2)
+			key := strings.ToLower(strings.TrimPrefix(parts[0], prefix))
+			cfg.Options[key] = parts[1]
+		}
+
+		cfgs = append(cfgs, cfg)
+	}
+
+	return cfgs
+}
+
+func splitCSV(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func envKey(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// WorkerCount reads TILTED_OUTPUTS_WORKERS, defaulting to 4.
+func WorkerCount() int {
+	if v := os.Getenv("TILTED_OUTPUTS_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 4
+}
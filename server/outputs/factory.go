@@ -0,0 +1,81 @@
+package outputs
+
+import "fmt"
+
+// Deps bundles the external dependencies a handful of output types need
+// to be constructed (an MQTT publisher for the "mqtt" type). Callers that
+// don't use those types can leave the corresponding field nil.
+type Deps struct {
+	MQTTPublisher Publisher
+}
+
+// Build constructs a Manager from the given output configs. workers
+// bounds the fan-out worker pool size.
+func Build(cfgs []Config, workers int, deps Deps) (*Manager, error) {
+	var regs []registered
+
+	for _, cfg := range cfgs {
+		out, err := build(cfg, deps)
+		if err != nil {
+			return nil, fmt.Errorf("outputs: build %q: %w", cfg.Name, err)
+		}
+		regs = append(regs, registered{
+			output: out,
+			filter: Filter{Include: cfg.Include, Exclude: cfg.Exclude},
+		})
+	}
+
+	return NewManager(regs, workers), nil
+}
+
+func build(cfg Config, deps Deps) (Output, error) {
+	switch cfg.Type {
+	case "brewfather":
+		url := cfg.Options["url"]
+		if url == "" {
+			return nil, fmt.Errorf("missing options.url")
+		}
+		return NewBrewfatherOutput(cfg.Name, url), nil
+
+	case "http":
+		url := cfg.Options["url"]
+		if url == "" {
+			return nil, fmt.Errorf("missing options.url")
+		}
+		headers := map[string]string{}
+		for k, v := range cfg.Options {
+			const headerPrefix = "header."
+			if len(k) > len(headerPrefix) && k[:len(headerPrefix)] == headerPrefix {
+				headers[k[len(headerPrefix):]] = v
+			}
+		}
+		return NewHTTPOutput(cfg.Name, url, cfg.Options["method"], headers)
+
+	case "influxdb":
+		url := cfg.Options["url"]
+		if url == "" {
+			return nil, fmt.Errorf("missing options.url")
+		}
+		return NewInfluxDBOutput(cfg.Name, url, cfg.Options["token"], cfg.Options["measurement"]), nil
+
+	case "mqtt":
+		if deps.MQTTPublisher == nil {
+			return nil, fmt.Errorf("mqtt output configured but no MQTT client is connected")
+		}
+		prefix := cfg.Options["prefix"]
+		if prefix == "" {
+			prefix = "tilted"
+		}
+		return NewMQTTOutput(cfg.Name, deps.MQTTPublisher, prefix), nil
+
+	case "file":
+		path := cfg.Options["path"]
+		if path == "" {
+			return nil, fmt.Errorf("missing options.path")
+		}
+		return NewFileOutput(cfg.Name, path)
+
+	default:
+		return nil, fmt.Errorf("unknown output type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,46 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileOutput appends each reading as a single JSON line to a local file,
+// useful as a cheap local backup sink or for feeding other tooling via a
+// tail -f.
+type FileOutput struct {
+	name string
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// NewFileOutput opens (creating if necessary) path for appending.
+func NewFileOutput(name, path string) (*FileOutput, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return &FileOutput{name: name, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (o *FileOutput) Name() string { return o.name }
+
+func (o *FileOutput) Write(_ context.Context, readings []*SensorReading) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, r := range readings {
+		if err := o.enc.Encode(r); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *FileOutput) Close() error {
+	return o.f.Close()
+}
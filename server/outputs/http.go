@@ -0,0 +1,93 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// HTTPOutput is a generic webhook sink: it POSTs each reading as JSON to
+// a configured URL, with header values rendered as templates so users can
+// inject auth tokens or sensor-specific routing info, e.g.
+// "Authorization: Bearer {{.Reading.SensorID}}".
+type HTTPOutput struct {
+	name    string
+	url     string
+	method  string
+	headers map[string]*template.Template
+	client  *http.Client
+}
+
+// NewHTTPOutput builds an HTTPOutput. headers maps header name to a
+// text/template string evaluated against the *SensorReading being sent.
+func NewHTTPOutput(name, url, method string, headers map[string]string) (*HTTPOutput, error) {
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	parsed := make(map[string]*template.Template, len(headers))
+	for k, v := range headers {
+		tmpl, err := template.New(k).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse header template %q: %w", k, err)
+		}
+		parsed[k] = tmpl
+	}
+
+	return &HTTPOutput{
+		name:    name,
+		url:     url,
+		method:  method,
+		headers: parsed,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (o *HTTPOutput) Name() string { return o.name }
+
+func (o *HTTPOutput) Write(ctx context.Context, readings []*SensorReading) error {
+	for _, r := range readings {
+		if err := o.writeOne(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *HTTPOutput) writeOne(ctx context.Context, r *SensorReading) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, o.method, o.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, tmpl := range o.headers {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, r); err != nil {
+			return fmt.Errorf("render header %q: %w", name, err)
+		}
+		req.Header.Set(name, buf.String())
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http %s: %w", o.method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (o *HTTPOutput) Close() error { return nil }
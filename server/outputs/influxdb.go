@@ -0,0 +1,84 @@
+package outputs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// InfluxDBOutput writes readings to an InfluxDB v2 bucket using the line
+// protocol write API.
+type InfluxDBOutput struct {
+	name        string
+	writeURL    string // e.g. http://host:8086/api/v2/write?org=...&bucket=...
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+// NewInfluxDBOutput builds an InfluxDBOutput. writeURL must already
+// include the org/bucket query parameters. Timestamps are always written
+// in nanoseconds, so writeURL must not set a precision other than ns.
+func NewInfluxDBOutput(name, writeURL, token, measurement string) *InfluxDBOutput {
+	if measurement == "" {
+		measurement = "tilted_reading"
+	}
+	return &InfluxDBOutput{
+		name:        name,
+		writeURL:    writeURL,
+		token:       token,
+		measurement: measurement,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (o *InfluxDBOutput) Name() string { return o.name }
+
+func (o *InfluxDBOutput) Write(ctx context.Context, readings []*SensorReading) error {
+	var buf bytes.Buffer
+	for _, r := range readings {
+		// r.Timestamp is unix milliseconds; line protocol wants nanoseconds
+		// (InfluxDB's default write precision), and using the reading's own
+		// timestamp rather than time.Now() keeps a retried write's point
+		// anchored to when the sensor actually took the reading.
+		fmt.Fprintf(&buf, "%s,sensor_id=%s,gateway_id=%s gravity=%v,tilt=%v,temp=%v,volt=%v,interval=%di %d\n",
+			o.measurement,
+			escapeTag(r.Reading.SensorID),
+			escapeTag(r.GatewayID),
+			r.Reading.Gravity, r.Reading.Tilt, r.Reading.Temp, r.Reading.Volt, r.Reading.Interval,
+			r.Timestamp*int64(time.Millisecond),
+		)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.writeURL, &buf)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Token "+o.token)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (o *InfluxDBOutput) Close() error { return nil }
+
+// escapeTag escapes characters that are significant in line protocol tag
+// values (commas, spaces, equals signs).
+func escapeTag(s string) string {
+	r := strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=")
+	return r.Replace(s)
+}
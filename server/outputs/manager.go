@@ -0,0 +1,192 @@
+package outputs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ordspilleren/Tilted/server/metrics"
+)
+
+// registered pairs an Output with the filter that decides which readings
+// it receives.
+type registered struct {
+	output Output
+	filter Filter
+}
+
+// Manager fans a reading out to every registered Output whose filter
+// matches, via a bounded worker pool, and retries failed writes with
+// exponential backoff so one slow or unreachable sink can't block
+// ingestion.
+type Manager struct {
+	outputs []registered
+	jobs    chan job
+	done    chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+type job struct {
+	output   Output
+	readings []*SensorReading
+	attempt  int
+}
+
+const (
+	maxRetryAttempts = 5
+	retryBaseDelay   = 2 * time.Second
+	retryMaxDelay    = 2 * time.Minute
+)
+
+// NewManager starts a Manager with the given outputs and worker count.
+func NewManager(outs []registered, workers int) *Manager {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	m := &Manager{
+		outputs: outs,
+		jobs:    make(chan job, 256),
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+
+	return m
+}
+
+// Dispatch fans reading out to every matching output. It never blocks on
+// a slow output: the send onto the worker queue is non-blocking, and a
+// full queue drops the reading for that output (logging and counting it
+// as a failure) rather than stalling the caller - mirroring how Hub.Publish
+// drops slow WebSocket subscribers instead of blocking ingest.
+func (m *Manager) Dispatch(reading *SensorReading) {
+	for _, r := range m.outputs {
+		if !r.filter.Match(reading.Reading.SensorID) {
+			continue
+		}
+		j := job{output: r.output, readings: []*SensorReading{reading}}
+		if ok, reason := m.enqueue(j); !ok {
+			sink := r.output.Name()
+			log.Printf("outputs: %s job queue full, dropping reading", sink)
+			metrics.ForwardFailuresTotal.WithLabelValues(sink, reason).Inc()
+		}
+	}
+}
+
+// enqueue registers j as in-flight and sends it to the worker queue,
+// unless the manager is already closing. Close waits for inFlight to
+// drain before closing the outputs, so gating every send on m.closed
+// here (under the same lock Close sets it under) guarantees no job -
+// including a scheduled retry - ever reaches a worker after its output
+// has been closed.
+func (m *Manager) enqueue(j job) (ok bool, reason string) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return false, "queue_full"
+	}
+	m.inFlight.Add(1)
+	m.mu.Unlock()
+
+	select {
+	case m.jobs <- j:
+		return true, ""
+	default:
+		m.inFlight.Done()
+		return false, "queue_full"
+	}
+}
+
+func (m *Manager) worker() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case j := <-m.jobs:
+			m.run(j, j.attempt)
+		}
+	}
+}
+
+func (m *Manager) run(j job, attempt int) {
+	defer m.inFlight.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sink := j.output.Name()
+	metrics.ForwardAttemptsTotal.WithLabelValues(sink).Inc()
+
+	start := time.Now()
+	err := j.output.Write(ctx, j.readings)
+	metrics.ForwardLatencySeconds.WithLabelValues(sink).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		log.Printf("outputs: %s write failed (attempt %d): %v", sink, attempt+1, err)
+		metrics.ForwardFailuresTotal.WithLabelValues(sink, failureReason(err)).Inc()
+		m.scheduleRetry(j, attempt+1)
+	}
+}
+
+// failureReason buckets a write error into a coarse label for the
+// tilted_forward_failures_total reason dimension, so Prometheus cardinality
+// stays bounded regardless of how detailed the underlying error is.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// scheduleRetry requeues j onto the worker pool after an exponential
+// backoff delay, using a time.Timer so the retry actually sleeps until
+// it's due instead of busy-polling for it.
+func (m *Manager) scheduleRetry(j job, attempt int) {
+	if attempt > maxRetryAttempts {
+		log.Printf("outputs: %s dropping reading after %d attempts", j.output.Name(), attempt)
+		return
+	}
+
+	delay := retryBaseDelay * time.Duration(1<<uint(attempt-1))
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	j.attempt = attempt
+	time.AfterFunc(delay, func() {
+		if ok, _ := m.enqueue(j); !ok {
+			log.Printf("outputs: %s job queue full, dropping retry", j.output.Name())
+		}
+	})
+}
+
+// Close stops accepting work (including already-scheduled retries), waits
+// for every job currently queued or running to finish, and only then
+// closes the registered outputs. Gating on m.closed this way - rather
+// than just closing m.done - rules out a worker calling output.Write
+// concurrently with (or after) the output.Close() below.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	m.inFlight.Wait()
+	close(m.done)
+
+	for _, r := range m.outputs {
+		if err := r.output.Close(); err != nil {
+			log.Printf("outputs: %s close failed: %v", r.output.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Publisher is the subset of the mqtt.Client the MQTT output needs. It's
+// declared here rather than importing the mqtt package's Client type
+// directly so this package doesn't depend on a live broker connection to
+// be testable.
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// MQTTOutput republishes readings onto an MQTT topic, typically
+// "<prefix>/<sensorId>/normalized" for downstream consumers such as Home
+// Assistant or Node-RED.
+type MQTTOutput struct {
+	name   string
+	pub    Publisher
+	prefix string
+}
+
+// NewMQTTOutput builds an MQTTOutput that publishes through pub under
+// topics rooted at prefix.
+func NewMQTTOutput(name string, pub Publisher, prefix string) *MQTTOutput {
+	return &MQTTOutput{name: name, pub: pub, prefix: prefix}
+}
+
+func (o *MQTTOutput) Name() string { return o.name }
+
+func (o *MQTTOutput) Write(_ context.Context, readings []*SensorReading) error {
+	for _, r := range readings {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		topic := o.prefix + "/" + r.Reading.SensorID + "/normalized"
+		if err := o.pub.Publish(topic, b); err != nil {
+			return fmt.Errorf("publish %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+func (o *MQTTOutput) Close() error { return nil }
@@ -0,0 +1,73 @@
+// Package outputs implements a pluggable forwarder system for sensor
+// readings, modeled on Telegraf's output plugin pattern: each reading
+// saved by the server is fanned out to every configured Output whose
+// per-sensor filter matches. This lets one server feed Brewfather, a
+// homelab InfluxDB instance, and an MQTT bus at the same time without
+// bespoke glue code.
+package outputs
+
+import "context"
+
+// Output is a destination that saved readings can be forwarded to.
+type Output interface {
+	// Name identifies the output instance in logs and metrics.
+	Name() string
+	// Write forwards readings to the destination. Implementations should
+	// return a non-nil error on any failure so the caller can retry.
+	Write(ctx context.Context, readings []*SensorReading) error
+	// Close releases any resources (connections, open files) held by
+	// the output.
+	Close() error
+}
+
+// SensorReading mirrors the shape the server decodes incoming readings
+// into. It's declared here (rather than imported from the main package)
+// so this package has no dependency back on main.
+type SensorReading struct {
+	Reading     Reading `json:"reading"`
+	GatewayID   string  `json:"gatewayId"`
+	GatewayName string  `json:"gatewayName"`
+	// Timestamp is when the reading was taken (unix milliseconds, matching
+	// the server's readings.timestamp column). Outputs should record this
+	// rather than their own write-time: writes go through a retry queue
+	// with up to a few minutes of backoff, so write-time can lag the
+	// actual reading significantly.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Reading contains the actual sensor data.
+type Reading struct {
+	SensorID string  `json:"sensorId"`
+	Gravity  float64 `json:"gravity"`
+	Tilt     float64 `json:"tilt"`
+	Temp     float64 `json:"temp"`
+	Volt     float64 `json:"volt"`
+	Interval int     `json:"interval"`
+}
+
+// Filter decides whether a reading for a given sensor should be routed
+// to an output. An empty Include matches every sensor except those in
+// Exclude; a non-empty Include matches only those sensors (still subject
+// to Exclude).
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// Match reports whether sensorID passes the filter.
+func (f Filter) Match(sensorID string) bool {
+	for _, id := range f.Exclude {
+		if id == sensorID {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, id := range f.Include {
+		if id == sensorID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// resolution identifies one of the aggregate tables readings are rolled
+// up into. "raw" is the unaggregated readings table.
+type resolution struct {
+	name     string
+	table    string
+	bucketMs int64 // 0 for raw
+}
+
+var (
+	rawResolution = resolution{name: "raw", table: "readings"}
+	res5m         = resolution{name: "5m", table: "readings_5m", bucketMs: int64(5 * time.Minute / time.Millisecond)}
+	res1h         = resolution{name: "1h", table: "readings_1h", bucketMs: int64(time.Hour / time.Millisecond)}
+)
+
+// retentionConfig holds the tunables for the rollup/retention subsystem.
+// Populated from flags (which default from env vars) in initDB.
+type retentionConfig struct {
+	rawRetention   time.Duration
+	rollupInterval time.Duration
+}
+
+var retentionCfg retentionConfig
+
+func envDurationDefault(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid duration in %s=%q, using default %s: %v", name, v, def, err)
+		return def
+	}
+	return d
+}
+
+// createRollupTablesSQL adds the 5m/1h rollup tables and the rollup_state
+// cursor table alongside the raw readings table created in initDB.
+const createRollupTablesSQL = `
+    -- 5-minute rollup of readings, AVG'd for gravity/tilt/temp/volt, MAX for interval.
+    CREATE TABLE IF NOT EXISTS readings_5m (
+        bucket_ts INTEGER NOT NULL,
+        sensor_id INTEGER NOT NULL,
+        gateway_id INTEGER NOT NULL,
+        gravity REAL NOT NULL,
+        tilt REAL NOT NULL,
+        temp REAL NOT NULL,
+        volt REAL NOT NULL,
+        interval INTEGER NOT NULL,
+        PRIMARY KEY (sensor_id, gateway_id, bucket_ts),
+        FOREIGN KEY (sensor_id) REFERENCES sensors(id),
+        FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    ) WITHOUT ROWID;
+
+    -- 1-hour rollup, same shape as readings_5m.
+    CREATE TABLE IF NOT EXISTS readings_1h (
+        bucket_ts INTEGER NOT NULL,
+        sensor_id INTEGER NOT NULL,
+        gateway_id INTEGER NOT NULL,
+        gravity REAL NOT NULL,
+        tilt REAL NOT NULL,
+        temp REAL NOT NULL,
+        volt REAL NOT NULL,
+        interval INTEGER NOT NULL,
+        PRIMARY KEY (sensor_id, gateway_id, bucket_ts),
+        FOREIGN KEY (sensor_id) REFERENCES sensors(id),
+        FOREIGN KEY (gateway_id) REFERENCES gateways(id)
+    ) WITHOUT ROWID;
+
+    -- Tracks the last raw timestamp rolled into each resolution so the
+    -- rollup goroutine only ever scans new readings.
+    CREATE TABLE IF NOT EXISTS rollup_state (
+        resolution TEXT PRIMARY KEY,
+        last_rollup INTEGER NOT NULL DEFAULT 0
+    );
+    `
+
+// registerRetentionFlags defines the -raw-retention/-rollup-interval flags,
+// defaulting from RAW_RETENTION/ROLLUP_INTERVAL env vars (Go durations,
+// e.g. "14d" is invalid but "336h" works). Must be called before
+// flag.Parse().
+func registerRetentionFlags() (*time.Duration, *time.Duration) {
+	rawRetention := flag.Duration("raw-retention", envDurationDefault("RAW_RETENTION", 14*24*time.Hour),
+		"how long raw readings are kept before being pruned")
+	rollupInterval := flag.Duration("rollup-interval", envDurationDefault("ROLLUP_INTERVAL", 5*time.Minute),
+		"how often the rollup/retention sweep runs")
+	return rawRetention, rollupInterval
+}
+
+// startRetentionLoop runs rollupAndPrune on a ticker for the lifetime of
+// the process.
+func startRetentionLoop(pool *sqlitex.Pool, cfg retentionConfig) {
+	ticker := time.NewTicker(cfg.rollupInterval)
+	go func() {
+		for range ticker.C {
+			if err := rollupAndPrune(pool, cfg); err != nil {
+				log.Printf("retention: sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// rollupAndPrune rolls new raw readings into the 5m and 1h aggregate
+// tables and then deletes raw readings older than cfg.rawRetention.
+func rollupAndPrune(pool *sqlitex.Pool, cfg retentionConfig) error {
+	conn, err := takeConn(pool)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %v", err)
+	}
+	defer putConn(pool, conn)
+
+	for _, res := range []resolution{res5m, res1h} {
+		if err := rollupResolution(conn, res); err != nil {
+			return fmt.Errorf("rollup %s: %w", res.name, err)
+		}
+	}
+
+	cutoff := time.Now().Add(-cfg.rawRetention).UnixMilli()
+	err = sqlitex.Execute(conn, "DELETE FROM readings WHERE timestamp < ?", &sqlitex.ExecOptions{
+		Args: []any{cutoff},
+	})
+	if err != nil {
+		return fmt.Errorf("prune raw readings: %w", err)
+	}
+
+	return nil
+}
+
+// rollupResolution recomputes every bucket that received a new raw
+// reading since res's last_rollup cursor, then advances the cursor.
+//
+// It's tempting to aggregate only the rows newer than last_rollup, but
+// that's wrong: rollup-interval (the sweep cadence) is far smaller than
+// res.bucketMs for readings_1h, and isn't phase-aligned to bucket
+// boundaries for readings_5m either, so a given bucket is touched by
+// several sweeps before it's "done". Aggregating just the new slice and
+// then overwriting the stored row (ON CONFLICT DO UPDATE) would replace
+// the bucket's true average with the average of only its most recent
+// sliver, silently discarding the rest of the bucket's data. Instead we
+// find which buckets got new rows, then recompute each of those buckets
+// from the complete raw range, so the stored aggregate is always correct
+// regardless of how many times it's re-swept.
+func rollupResolution(conn *sqlite.Conn, res resolution) error {
+	var lastRollup int64
+	err := sqlitex.Execute(conn,
+		"SELECT last_rollup FROM rollup_state WHERE resolution = ?",
+		&sqlitex.ExecOptions{
+			Args: []any{res.name},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				lastRollup = stmt.ColumnInt64(0)
+				return nil
+			},
+		})
+	if err != nil {
+		return fmt.Errorf("read rollup_state: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+
+	insertSQL := fmt.Sprintf(`
+        INSERT INTO %s (bucket_ts, sensor_id, gateway_id, gravity, tilt, temp, volt, interval)
+        SELECT (timestamp / ?) * ?, sensor_id, gateway_id,
+               AVG(gravity), AVG(tilt), AVG(temp), AVG(volt), MAX(interval)
+        FROM readings
+        WHERE timestamp / ? IN (
+            SELECT DISTINCT timestamp / ?
+            FROM readings
+            WHERE timestamp > ? AND timestamp <= ?
+        )
+        GROUP BY sensor_id, gateway_id, timestamp / ?
+        ON CONFLICT(sensor_id, gateway_id, bucket_ts) DO UPDATE SET
+            gravity = excluded.gravity,
+            tilt = excluded.tilt,
+            temp = excluded.temp,
+            volt = excluded.volt,
+            interval = excluded.interval
+        `, res.table)
+
+	err = sqlitex.Execute(conn, insertSQL, &sqlitex.ExecOptions{
+		Args: []any{res.bucketMs, res.bucketMs, res.bucketMs, res.bucketMs, lastRollup, now, res.bucketMs},
+	})
+	if err != nil {
+		return fmt.Errorf("insert rollup: %w", err)
+	}
+
+	err = sqlitex.Execute(conn,
+		`INSERT INTO rollup_state (resolution, last_rollup) VALUES (?, ?)
+         ON CONFLICT(resolution) DO UPDATE SET last_rollup = excluded.last_rollup`,
+		&sqlitex.ExecOptions{Args: []any{res.name, now}})
+	if err != nil {
+		return fmt.Errorf("advance rollup cursor: %w", err)
+	}
+
+	return nil
+}
+
+// sensorDataQuery builds the query (and its args) used by getSensorData
+// for the chosen resolution. For an aggregate resolution it UNIONs the
+// rolled-up rows with a short tail of raw readings that are too recent
+// to have been rolled up yet, so charts don't lag behind live data.
+func sensorDataQuery(res resolution, sensorID string, startTime, endTime int64) (string, []any) {
+	if res.name == rawResolution.name {
+		query := `
+        SELECT r.timestamp, s.sensor_id, g.gateway_id, g.gateway_name, r.gravity, r.tilt, r.temp, r.volt, r.interval
+        FROM readings r
+        JOIN sensors s ON r.sensor_id = s.id
+        JOIN gateways g ON r.gateway_id = g.id
+        WHERE s.sensor_id = ? AND r.timestamp >= ? AND r.timestamp <= ?
+        ORDER BY r.timestamp ASC
+        `
+		return query, []any{sensorID, startTime, endTime}
+	}
+
+	tailWindow := int64(2 * retentionCfg.rollupInterval / time.Millisecond)
+
+	// Only union in a raw tail when endTime is recent enough to still be
+	// inside the rollup's in-flight window. A historical query (endTime
+	// well in the past) has already been fully rolled up, so res.table
+	// alone covers it; unioning raw rows there too would duplicate points
+	// that are already present in the aggregate table.
+	if endTime < time.Now().UnixMilli()-tailWindow {
+		query := fmt.Sprintf(`
+        SELECT r.bucket_ts AS timestamp, s.sensor_id, g.gateway_id, g.gateway_name, r.gravity, r.tilt, r.temp, r.volt, r.interval
+        FROM %s r
+        JOIN sensors s ON r.sensor_id = s.id
+        JOIN gateways g ON r.gateway_id = g.id
+        WHERE s.sensor_id = ? AND r.bucket_ts >= ? AND r.bucket_ts <= ?
+        ORDER BY timestamp ASC
+        `, res.table)
+		return query, []any{sensorID, startTime, endTime}
+	}
+
+	// Round the tail boundary down to a bucket edge so the aggregate and
+	// raw branches partition time exactly: the in-progress bucket that
+	// straddles endTime-tailWindow is excluded from the aggregate branch
+	// (its bucket_ts starts before the boundary but its raw rows extend
+	// past it) and covered in full by the raw branch instead. Without
+	// this, that bucket's data comes back twice - once smeared into the
+	// aggregate row, once again as raw points.
+	tailBoundary := (endTime - tailWindow) / res.bucketMs * res.bucketMs
+	if tailBoundary < startTime {
+		tailBoundary = startTime
+	}
+
+	query := fmt.Sprintf(`
+    SELECT r.bucket_ts AS timestamp, s.sensor_id, g.gateway_id, g.gateway_name, r.gravity, r.tilt, r.temp, r.volt, r.interval
+    FROM %s r
+    JOIN sensors s ON r.sensor_id = s.id
+    JOIN gateways g ON r.gateway_id = g.id
+    WHERE s.sensor_id = ? AND r.bucket_ts >= ? AND r.bucket_ts < ?
+    UNION ALL
+    SELECT r.timestamp AS timestamp, s.sensor_id, g.gateway_id, g.gateway_name, r.gravity, r.tilt, r.temp, r.volt, r.interval
+    FROM readings r
+    JOIN sensors s ON r.sensor_id = s.id
+    JOIN gateways g ON r.gateway_id = g.id
+    WHERE s.sensor_id = ? AND r.timestamp >= ? AND r.timestamp <= ?
+    ORDER BY timestamp ASC
+    `, res.table)
+
+	return query, []any{sensorID, startTime, tailBoundary, sensorID, tailBoundary, endTime}
+}
+
+// chooseResolution picks the coarsest resolution that still gives a
+// reasonable number of points over [startTime, endTime], so long-range
+// charts stay fast as the readings table grows.
+func chooseResolution(startTime, endTime int64) resolution {
+	span := time.Duration(endTime-startTime) * time.Millisecond
+	switch {
+	case span > 7*24*time.Hour:
+		return res1h
+	case span > 24*time.Hour:
+		return res5m
+	default:
+		return rawResolution
+	}
+}
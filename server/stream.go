@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// StreamEvent is the JSON frame pushed to /api/stream subscribers: the
+// same shape as DataPoint, plus the sensor/gateway identifiers clients
+// need to route it to the right chart.
+type StreamEvent struct {
+	DataPoint
+	SensorID    string `json:"sensorId"`
+	GatewayName string `json:"gatewayName"`
+}
+
+// Hub is a small pub/sub broker that fans newly ingested readings out to
+// any number of WebSocket subscribers, each optionally filtered to a
+// single sensor.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan StreamEvent]string // channel -> sensorID filter ("" = all)
+}
+
+func newHub() *Hub {
+	return &Hub{subs: make(map[chan StreamEvent]string)}
+}
+
+// streamHub is the process-wide hub saveToDatabase publishes into.
+var streamHub = newHub()
+
+// Subscribe registers a new buffered channel filtered to sensorID (or
+// every sensor if sensorID is empty).
+func (h *Hub) Subscribe(sensorID string) chan StreamEvent {
+	ch := make(chan StreamEvent, 16)
+
+	h.mu.Lock()
+	h.subs[ch] = sensorID
+	h.mu.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes and closes ch.
+func (h *Hub) Unsubscribe(ch chan StreamEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+
+	close(ch)
+}
+
+// Publish fans event out to every matching subscriber. A subscriber whose
+// buffer is full is dropped for this event rather than blocking ingest -
+// a slow WebSocket client shouldn't be able to stall saving readings.
+func (h *Hub) Publish(event StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch, sensorID := range h.subs {
+		if sensorID != "" && sensorID != event.SensorID {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			log.Printf("stream: subscriber buffer full, dropping event for sensor %s", event.SensorID)
+		}
+	}
+}
+
+var streamUpgrader = websocket.Upgrader{
+	// The frontend and API are served from the same origin in production;
+	// same-origin dev proxies (e.g. the Svelte dev server) are the only
+	// other expected caller, so we don't gate on Origin here.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleStream upgrades to a WebSocket and streams every newly ingested
+// reading as a StreamEvent, optionally filtered to ?sensorId=.
+func handleStream(c echo.Context) error {
+	sensorID := c.QueryParam("sensorId")
+
+	conn, err := streamUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch := streamHub.Subscribe(sensorID)
+	defer streamHub.Unsubscribe(ch)
+
+	// Detect the client going away (close frame or dropped connection) so
+	// we stop blocking on conn.WriteJSON for a peer that's no longer there.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return nil
+		case event, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return nil
+			}
+		}
+	}
+}